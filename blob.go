@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// BlobStorage is implemented by backends that can stream large values
+// instead of round-tripping them through a JSON-encoded interface{}. It
+// sits alongside CacheStorage rather than replacing it.
+type BlobStorage interface {
+	// PutStream copies r into the backend, returning the hex-encoded
+	// sha256 digest of what was written. In content-addressed mode the
+	// digest also becomes the storage key, so GetStream must be called
+	// with the digest rather than the caller's original key.
+	PutStream(key string, r io.Reader, ttl time.Duration) (sha256Hex string, err error)
+	GetStream(key string) (io.ReadCloser, error)
+}
+
+const blobKeyPrefix = "blob:"
+
+func blobIndexKey(key string) string {
+	return blobKeyPrefix + key
+}
+
+// blobDigestForKey reports the digest a blob index entry points at, given
+// the cache key and value it was stored under. It returns false for any key
+// that isn't a blob index entry.
+func blobDigestForKey(key string, value interface{}) (string, bool) {
+	if !strings.HasPrefix(key, blobKeyPrefix) {
+		return "", false
+	}
+	digest, ok := value.(string)
+	return digest, ok
+}
+
+// verifyBlobDigest re-reads path from disk and confirms its sha256 matches
+// digest, catching corruption that the write itself introduced rather than
+// trusting the digest computed while streaming.
+func verifyBlobDigest(path, digest string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("blob integrity check: re-read failed: %w", err)
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return fmt.Errorf("blob integrity check: re-read failed: %w", err)
+	}
+	if got := hex.EncodeToString(hasher.Sum(nil)); got != digest {
+		return fmt.Errorf("blob integrity check failed: wrote digest %s but disk has %s", digest, got)
+	}
+	return nil
+}
+
+// FileStorage
+
+func (f *FileStorage) blobsDir() string {
+	return f.filePath + ".blobs"
+}
+
+// gcBlob removes digest's file from blobsDir if no remaining index entry
+// still references it (content-addressed mode lets multiple keys share a
+// digest). Callers must hold f.mu.
+func (f *FileStorage) gcBlob(digest string) {
+	for key, item := range f.cache {
+		if d, ok := blobDigestForKey(key, item.Value); ok && d == digest {
+			return
+		}
+	}
+	os.Remove(filepath.Join(f.blobsDir(), digest))
+}
+
+func (f *FileStorage) PutStream(key string, r io.Reader, ttl time.Duration) (string, error) {
+	if err := os.MkdirAll(f.blobsDir(), 0755); err != nil {
+		return "", err
+	}
+
+	tmp, err := ioutil.TempFile(f.blobsDir(), "upload-*")
+	if err != nil {
+		return "", err
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher), r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	tmp.Close()
+
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	finalPath := filepath.Join(f.blobsDir(), digest)
+	if err := os.Rename(tmp.Name(), finalPath); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := verifyBlobDigest(finalPath, digest); err != nil {
+		os.Remove(finalPath)
+		return "", err
+	}
+
+	storeKey := key
+	if f.contentAddressed {
+		storeKey = digest
+	}
+	if err := f.Put(blobIndexKey(storeKey), digest, ttl); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+func (f *FileStorage) GetStream(key string) (io.ReadCloser, error) {
+	value, err := f.Get(blobIndexKey(key))
+	if err != nil {
+		return nil, err
+	}
+	digest, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("corrupt blob index for key %q", key)
+	}
+	return os.Open(filepath.Join(f.blobsDir(), digest))
+}
+
+// RedisStorage
+
+// blobDataKey namespaces a digest's raw blob bytes separately from
+// blobIndexKey, which maps a caller's key to a digest: in content-addressed
+// mode the caller's key *is* the digest, and the two would otherwise land on
+// the same Redis key, letting the index-entry write clobber the raw bytes.
+func blobDataKey(digest string) string {
+	return "blobdata:" + digest
+}
+
+func (r *RedisStorage) PutStream(key string, rd io.Reader, ttl time.Duration) (string, error) {
+	var buf bytes.Buffer
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(&buf, hasher), rd); err != nil {
+		return "", err
+	}
+	digest := hex.EncodeToString(hasher.Sum(nil))
+
+	// Two different keys can hash to the same digest and PutStream the
+	// identical bytes with different TTLs. Never shrink the shared data
+	// key's TTL below what an earlier, still-live caller asked for, or
+	// its blob would expire out from under that caller's index entry.
+	dataKey := blobDataKey(digest)
+	writeTTL := ttl
+	if existing, err := r.client.TTL(r.ctx, dataKey).Result(); err == nil && existing > writeTTL {
+		writeTTL = existing
+	}
+	if err := r.client.Set(r.ctx, dataKey, buf.Bytes(), writeTTL).Err(); err != nil {
+		return "", err
+	}
+	stored, err := r.client.Get(r.ctx, dataKey).Bytes()
+	if err != nil {
+		return "", fmt.Errorf("blob integrity check: re-read failed: %w", err)
+	}
+	if sum := sha256.Sum256(stored); hex.EncodeToString(sum[:]) != digest {
+		r.client.Del(r.ctx, dataKey)
+		return "", fmt.Errorf("blob integrity check failed: wrote digest %s but redis has %s", digest, hex.EncodeToString(sum[:]))
+	}
+
+	storeKey := key
+	if r.contentAddressed {
+		storeKey = digest
+	}
+	if err := r.Put(blobIndexKey(storeKey), digest, ttl); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+func (r *RedisStorage) GetStream(key string) (io.ReadCloser, error) {
+	value, err := r.Get(blobIndexKey(key))
+	if err != nil {
+		return nil, err
+	}
+	digest, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("corrupt blob index for key %q", key)
+	}
+	data, err := r.client.Get(r.ctx, blobDataKey(digest)).Bytes()
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// S3Storage
+
+// PutStream multipart-uploads r to a staging object, computing its sha256
+// digest as it streams, then copies the staging object to its final key
+// (the digest itself in ContentAddressed mode) with the digest recorded in
+// object metadata for integrity checks.
+func (s *S3Storage) PutStream(key string, r io.Reader, ttl time.Duration) (string, error) {
+	uploader := s3manager.NewUploaderWithClient(s.s3, func(u *s3manager.Uploader) {
+		if s.cfg.PartSize > 0 {
+			u.PartSize = s.cfg.PartSize
+		}
+		if s.cfg.UploadConcurrency > 0 {
+			u.Concurrency = s.cfg.UploadConcurrency
+		}
+	})
+
+	hasher := sha256.New()
+	stagingKey := s.objectKey("staging/" + key)
+	_, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(stagingKey),
+		Body:   io.TeeReader(r, hasher),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	finalKey := s.objectKey(key)
+	if s.cfg.ContentAddressed {
+		finalKey = s.objectKey(digest)
+	}
+
+	_, err = s.s3.CopyObject(&s3.CopyObjectInput{
+		Bucket:            aws.String(s.bucket),
+		CopySource:        aws.String(s.bucket + "/" + stagingKey),
+		Key:               aws.String(finalKey),
+		MetadataDirective: aws.String("REPLACE"),
+		Metadata: map[string]*string{
+			"Sha256": aws.String(digest),
+			"Expiry": aws.String(time.Now().Add(ttl).Format(time.RFC3339Nano)),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.verifyBlobDigest(finalKey, digest); err != nil {
+		s.s3.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(finalKey)})
+		s.s3.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(stagingKey)})
+		return "", err
+	}
+
+	if _, err := s.s3.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(stagingKey)}); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// verifyBlobDigest re-reads objKey from S3 and confirms its sha256 matches
+// digest, catching corruption introduced in transit or by the copy itself.
+func (s *S3Storage) verifyBlobDigest(objKey, digest string) error {
+	output, err := s.s3.GetObject(&s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(objKey)})
+	if err != nil {
+		return fmt.Errorf("blob integrity check: re-read failed: %w", err)
+	}
+	defer output.Body.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, output.Body); err != nil {
+		return fmt.Errorf("blob integrity check: re-read failed: %w", err)
+	}
+	if got := hex.EncodeToString(hasher.Sum(nil)); got != digest {
+		return fmt.Errorf("blob integrity check failed: wrote digest %s but s3 has %s", digest, got)
+	}
+	return nil
+}
+
+func (s *S3Storage) GetStream(key string) (io.ReadCloser, error) {
+	objKey := s.objectKey(key)
+	head, err := s.s3.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(objKey)})
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if expiry, ok := head.Metadata["Expiry"]; ok && expiry != nil {
+		expiresAt, err := time.Parse(time.RFC3339Nano, *expiry)
+		if err == nil && time.Now().After(expiresAt) {
+			return nil, ErrNotFound
+		}
+	}
+
+	output, err := s.s3.GetObject(&s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(objKey)})
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return output.Body, nil
+}
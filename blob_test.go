@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFileStorage(t *testing.T) *FileStorage {
+	t.Helper()
+	dir := t.TempDir()
+	fs := NewFileStorage(filepath.Join(dir, "cache.json"))
+	t.Cleanup(func() { fs.Stop() })
+	return fs
+}
+
+func TestFileStoragePutStreamWritesVerifiedBlobFile(t *testing.T) {
+	fs := newTestFileStorage(t)
+
+	digest, err := fs.PutStream("greeting", strings.NewReader("hello world"), time.Minute)
+	if err != nil {
+		t.Fatalf("PutStream: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(fs.blobsDir(), digest)); err != nil {
+		t.Fatalf("expected blob file on disk: %v", err)
+	}
+
+	rc, err := fs.GetStream("greeting")
+	if err != nil {
+		t.Fatalf("GetStream: %v", err)
+	}
+	defer rc.Close()
+}
+
+func TestFileStorageSweepDeletesOrphanedBlobFile(t *testing.T) {
+	fs := newTestFileStorage(t)
+
+	digest, err := fs.PutStream("greeting", strings.NewReader("hello world"), -time.Second)
+	if err != nil {
+		t.Fatalf("PutStream: %v", err)
+	}
+	blobPath := filepath.Join(fs.blobsDir(), digest)
+	if _, err := os.Stat(blobPath); err != nil {
+		t.Fatalf("expected blob file on disk before sweep: %v", err)
+	}
+
+	fs.sweep()
+
+	if _, err := os.Stat(blobPath); !os.IsNotExist(err) {
+		t.Fatalf("expected sweep to remove orphaned blob file, stat err = %v", err)
+	}
+}
+
+func TestFileStorageGCKeepsSharedDigestAlive(t *testing.T) {
+	fs := newTestFileStorage(t)
+
+	digest, err := fs.PutStream("first", strings.NewReader("same bytes"), time.Minute)
+	if err != nil {
+		t.Fatalf("PutStream: %v", err)
+	}
+	if _, err := fs.PutStream("second", strings.NewReader("same bytes"), -time.Second); err != nil {
+		t.Fatalf("PutStream: %v", err)
+	}
+
+	fs.sweep()
+
+	blobPath := filepath.Join(fs.blobsDir(), digest)
+	if _, err := os.Stat(blobPath); err != nil {
+		t.Fatalf("expected blob file to survive while a live key still references its digest: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Logger is the minimal logging interface a Driver needs. *log.Logger
+// satisfies it, so callers that don't care can pass log.Default().
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// Driver constructs a CacheStorage from its JSON-encoded parameters. Each
+// concrete backend registers its own Driver in an init() function so new
+// backends (GCS, Azure Blob, memcached, BoltDB, ...) can be added without
+// touching this file.
+type Driver func(params json.RawMessage, logger Logger) (CacheStorage, error)
+
+var drivers = map[string]Driver{}
+
+// Register adds a Driver under name, so it can later be selected by
+// BackendConfig.Driver or looked up directly with Open. Register panics on
+// a duplicate name, matching the usual database/sql-style driver registry.
+func Register(name string, d Driver) {
+	if _, exists := drivers[name]; exists {
+		panic(fmt.Sprintf("driver: Register called twice for driver %q", name))
+	}
+	drivers[name] = d
+}
+
+// Open builds a CacheStorage using the driver registered as name, logging
+// through the standard logger. Use OpenWithLogger to supply your own.
+func Open(name string, params json.RawMessage) (CacheStorage, error) {
+	return OpenWithLogger(name, params, log.New(os.Stderr, "", log.LstdFlags))
+}
+
+// OpenWithLogger is like Open but lets the caller supply the Logger that
+// gets threaded down into the driver.
+func OpenWithLogger(name string, params json.RawMessage, logger Logger) (CacheStorage, error) {
+	d, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("driver: no driver registered under name %q", name)
+	}
+	return d(params, logger)
+}
+
+// BackendConfig names one driver and the parameters it should be opened
+// with, mirroring Arvados' `driver["S3"]` + `DriverParameters` convention.
+type BackendConfig struct {
+	Driver           string          `json:"Driver"`
+	DriverParameters json.RawMessage `json:"DriverParameters"`
+}
+
+// Config lists the named backends a process should construct, e.g. parsed
+// from a JSON or YAML config file.
+type Config struct {
+	Backends map[string]BackendConfig `json:"Backends"`
+}
+
+// Open builds every backend listed in c.Backends, keyed by its config name.
+func (c *Config) Open(logger Logger) (map[string]CacheStorage, error) {
+	out := make(map[string]CacheStorage, len(c.Backends))
+	for name, bc := range c.Backends {
+		storage, err := OpenWithLogger(bc.Driver, bc.DriverParameters, logger)
+		if err != nil {
+			return nil, fmt.Errorf("driver: opening backend %q: %w", name, err)
+		}
+		out[name] = storage
+	}
+	return out, nil
+}
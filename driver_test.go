@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type testLogger struct{}
+
+func (testLogger) Printf(format string, args ...interface{}) {}
+
+func TestRegisterPanicsOnDuplicateName(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected Register to panic on a duplicate driver name")
+		}
+	}()
+	noop := func(json.RawMessage, Logger) (CacheStorage, error) { return nil, nil }
+	Register("test-dup-driver", noop)
+	Register("test-dup-driver", noop)
+}
+
+func TestOpenUnknownDriver(t *testing.T) {
+	if _, err := Open("test-does-not-exist", nil); err == nil {
+		t.Fatal("expected Open to fail for an unregistered driver name")
+	}
+}
+
+func TestOpenWithLoggerThreadsLoggerToDriver(t *testing.T) {
+	var gotLogger Logger
+	Register("test-logger-capture", func(params json.RawMessage, logger Logger) (CacheStorage, error) {
+		gotLogger = logger
+		return newFakeStorage(), nil
+	})
+
+	want := testLogger{}
+	if _, err := OpenWithLogger("test-logger-capture", nil, want); err != nil {
+		t.Fatalf("OpenWithLogger: %v", err)
+	}
+	if gotLogger != want {
+		t.Fatalf("expected driver to receive the supplied Logger, got %v", gotLogger)
+	}
+}
+
+func TestConfigOpenBuildsEveryBackend(t *testing.T) {
+	Register("test-config-backend", func(json.RawMessage, Logger) (CacheStorage, error) {
+		return newFakeStorage(), nil
+	})
+
+	cfg := &Config{Backends: map[string]BackendConfig{
+		"primary": {Driver: "test-config-backend"},
+	}}
+	backends, err := cfg.Open(testLogger{})
+	if err != nil {
+		t.Fatalf("Config.Open: %v", err)
+	}
+	if _, ok := backends["primary"]; !ok {
+		t.Fatal("expected Config.Open to build the configured backend under its name")
+	}
+}
+
+func TestConfigOpenFailsOnUnknownDriver(t *testing.T) {
+	cfg := &Config{Backends: map[string]BackendConfig{
+		"primary": {Driver: "test-does-not-exist-either"},
+	}}
+	if _, err := cfg.Open(testLogger{}); err == nil {
+		t.Fatal("expected Config.Open to fail when a backend names an unregistered driver")
+	}
+}
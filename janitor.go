@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// janitor runs fn on a tick until Stop is called. It's the shared
+// background-sweep/flush primitive used by MemoryStorage, FileStorage and
+// S3Storage: each constructor starts one, and Stop stops it.
+type janitor struct {
+	interval time.Duration
+	fn       func()
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newJanitor(interval time.Duration, fn func()) *janitor {
+	j := &janitor{interval: interval, fn: fn, stop: make(chan struct{})}
+	if interval > 0 {
+		go j.run()
+	}
+	return j
+}
+
+func (j *janitor) run() {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			j.fn()
+		}
+	}
+}
+
+func (j *janitor) Stop() {
+	j.stopOnce.Do(func() { close(j.stop) })
+}
+
+// CacheStats are the sweep/flush counters a backend can expose so the
+// metrics subsystem (see MetricsCache) can report them as gauges/counters
+// rather than just latency and hit/miss.
+type CacheStats struct {
+	Entries      int
+	ExpiredSwept int64
+	Flushes      int64
+}
+
+// StatsProvider is implemented by backends that track CacheStats.
+type StatsProvider interface {
+	Stats() CacheStats
+}
+
+// sweepStats is an atomic counter pair embedded by backends that run a
+// janitor, so Stats() and the sweep loop can share it without a lock.
+type sweepStats struct {
+	expiredSwept int64
+	flushes      int64
+}
+
+func (s *sweepStats) recordSweep(n int) {
+	if n > 0 {
+		atomic.AddInt64(&s.expiredSwept, int64(n))
+	}
+}
+
+func (s *sweepStats) recordFlush() {
+	atomic.AddInt64(&s.flushes, 1)
+}
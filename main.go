@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -13,6 +18,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 type CacheStorage interface {
@@ -22,9 +28,27 @@ type CacheStorage interface {
 	Stop() error
 }
 
+// ErrNotFound is the sentinel every CacheStorage backend (built-in or
+// third-party, via the Driver registry in driver.go) should wrap with
+// fmt.Errorf("...: %w", ErrNotFound) when a key is missing or expired,
+// instead of returning an error whose text happens to match. Callers that
+// care about that case specifically (e.g. MetricsCache.observe) compare
+// with errors.Is rather than relying on exact wording.
+var ErrNotFound = errors.New("key not found or expired")
+
+// DefaultSweepInterval is how often a janitor scans for expired entries
+// when NewMemoryStorage or NewFileStorage isn't given an explicit sweep
+// interval option. NewS3Storage does not use it: a zero
+// S3Config.SweepInterval disables the S3 sweeper.
+const DefaultSweepInterval = time.Minute
+
 type MemoryStorage struct {
 	cache map[string]cacheItem
 	mu    sync.Mutex
+
+	janitor       *janitor
+	sweepInterval time.Duration
+	sweepStats
 }
 
 type cacheItem struct {
@@ -32,8 +56,45 @@ type cacheItem struct {
 	Expiry time.Time
 }
 
-func NewMemoryStorage() *MemoryStorage {
-	return &MemoryStorage{cache: make(map[string]cacheItem)}
+// MemoryOption configures a MemoryStorage at construction time.
+type MemoryOption func(*MemoryStorage)
+
+// WithMemorySweepInterval overrides DefaultSweepInterval for a
+// MemoryStorage's background expiry sweep. Zero disables the sweeper,
+// falling back to the old evict-on-read-only behavior.
+func WithMemorySweepInterval(d time.Duration) MemoryOption {
+	return func(m *MemoryStorage) { m.sweepInterval = d }
+}
+
+func NewMemoryStorage(opts ...MemoryOption) *MemoryStorage {
+	m := &MemoryStorage{cache: make(map[string]cacheItem), sweepInterval: DefaultSweepInterval}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.janitor = newJanitor(m.sweepInterval, m.sweep)
+	return m
+}
+
+func init() {
+	Register("memory", func(params json.RawMessage, logger Logger) (CacheStorage, error) {
+		return NewMemoryStorage(), nil
+	})
+}
+
+// sweep evicts every expired entry; it's the janitor's periodic callback.
+func (m *MemoryStorage) sweep() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	swept := 0
+	for key, item := range m.cache {
+		if now.After(item.Expiry) {
+			delete(m.cache, key)
+			swept++
+		}
+	}
+	m.recordSweep(swept)
 }
 
 func (m *MemoryStorage) Get(key string) (interface{}, error) {
@@ -43,7 +104,7 @@ func (m *MemoryStorage) Get(key string) (interface{}, error) {
 	item, exists := m.cache[key]
 	if !exists || time.Now().After(item.Expiry) {
 		delete(m.cache, key)
-		return nil, fmt.Errorf("key not found or expired")
+		return nil, ErrNotFound
 	}
 	return item.Value, nil
 }
@@ -65,23 +126,94 @@ func (m *MemoryStorage) Delete(key string) error {
 }
 
 func (m *MemoryStorage) Stop() error {
+	m.janitor.Stop()
 	return nil
 }
 
+func (m *MemoryStorage) Stats() CacheStats {
+	m.mu.Lock()
+	entries := len(m.cache)
+	m.mu.Unlock()
+	return CacheStats{
+		Entries:      entries,
+		ExpiredSwept: atomic.LoadInt64(&m.expiredSwept),
+	}
+}
+
 // FileStorage
 
 type FileStorage struct {
 	filePath string
 	cache    map[string]cacheItem
 	mu       sync.Mutex
+
+	// contentAddressed makes PutStream key blobs by their own sha256
+	// digest instead of the caller-supplied key. See SetContentAddressed.
+	contentAddressed bool
+
+	dirty         bool
+	sweepInterval time.Duration
+	flushInterval time.Duration
+	janitor       *janitor
+	flusher       *janitor
+	sweepStats
+}
+
+// SetContentAddressed toggles content-addressed mode for blob storage: see
+// BlobStorage.PutStream.
+func (f *FileStorage) SetContentAddressed(enabled bool) {
+	f.contentAddressed = enabled
+}
+
+// FileOption configures a FileStorage at construction time.
+type FileOption func(*FileStorage)
+
+// WithFileSweepInterval overrides DefaultSweepInterval for a FileStorage's
+// background expiry sweep.
+func WithFileSweepInterval(d time.Duration) FileOption {
+	return func(f *FileStorage) { f.sweepInterval = d }
+}
+
+// WithFileFlushInterval controls how often a dirty in-memory cache gets
+// rewritten to disk, instead of on every single Put/Delete. Mutations are
+// still flushed immediately on Stop. Zero flushes synchronously, matching
+// the original behavior.
+func WithFileFlushInterval(d time.Duration) FileOption {
+	return func(f *FileStorage) { f.flushInterval = d }
 }
 
-func NewFileStorage(filePath string) *FileStorage {
-	fs := &FileStorage{filePath: filePath, cache: make(map[string]cacheItem)}
+func NewFileStorage(filePath string, opts ...FileOption) *FileStorage {
+	fs := &FileStorage{
+		filePath:      filePath,
+		cache:         make(map[string]cacheItem),
+		sweepInterval: DefaultSweepInterval,
+	}
+	for _, opt := range opts {
+		opt(fs)
+	}
 	fs.loadFromFile()
+	fs.janitor = newJanitor(fs.sweepInterval, fs.sweep)
+	if fs.flushInterval > 0 {
+		fs.flusher = newJanitor(fs.flushInterval, fs.flushIfDirty)
+	}
 	return fs
 }
 
+// FileParams are the DriverParameters for the "file" driver.
+type FileParams struct {
+	FilePath string `json:"FilePath"`
+}
+
+func init() {
+	Register("file", func(params json.RawMessage, logger Logger) (CacheStorage, error) {
+		var p FileParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, fmt.Errorf("file driver: %w", err)
+		}
+		return NewFileStorage(p.FilePath), nil
+	})
+}
+
 func (f *FileStorage) loadFromFile() {
 	data, err := ioutil.ReadFile(f.filePath)
 	if err == nil {
@@ -89,9 +221,68 @@ func (f *FileStorage) loadFromFile() {
 	}
 }
 
+// saveToFile writes the cache to disk via a temp file + atomic rename, so a
+// crash mid-write can't leave a truncated cache.json behind.
 func (f *FileStorage) saveToFile() {
-	data, _ := json.Marshal(f.cache)
-	_ = ioutil.WriteFile(f.filePath, data, 0644)
+	data, err := json.Marshal(f.cache)
+	if err != nil {
+		return
+	}
+	tmp := f.filePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, f.filePath); err != nil {
+		return
+	}
+	f.dirty = false
+	f.recordFlush()
+}
+
+// markDirty records that the in-memory cache no longer matches disk. If
+// there's no background flusher configured, it flushes immediately so
+// behavior matches the original synchronous-write FileStorage.
+func (f *FileStorage) markDirty() {
+	f.dirty = true
+	if f.flusher == nil {
+		f.saveToFile()
+	}
+}
+
+func (f *FileStorage) flushIfDirty() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.dirty {
+		f.saveToFile()
+	}
+}
+
+// sweep evicts every expired entry; it's the janitor's periodic callback.
+// Expired blob index entries also have their backing file on disk collected,
+// so blobsDir doesn't grow without bound.
+func (f *FileStorage) sweep() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	swept := 0
+	var expiredDigests []string
+	for key, item := range f.cache {
+		if now.After(item.Expiry) {
+			delete(f.cache, key)
+			swept++
+			if digest, ok := blobDigestForKey(key, item.Value); ok {
+				expiredDigests = append(expiredDigests, digest)
+			}
+		}
+	}
+	if swept > 0 {
+		f.markDirty()
+	}
+	f.recordSweep(swept)
+	for _, digest := range expiredDigests {
+		f.gcBlob(digest)
+	}
 }
 
 func (f *FileStorage) Get(key string) (interface{}, error) {
@@ -101,8 +292,13 @@ func (f *FileStorage) Get(key string) (interface{}, error) {
 	item, exists := f.cache[key]
 	if !exists || time.Now().After(item.Expiry) {
 		delete(f.cache, key)
-		f.saveToFile()
-		return nil, fmt.Errorf("key not found or expired")
+		f.markDirty()
+		if exists {
+			if digest, ok := blobDigestForKey(key, item.Value); ok {
+				f.gcBlob(digest)
+			}
+		}
+		return nil, ErrNotFound
 	}
 	return item.Value, nil
 }
@@ -112,7 +308,7 @@ func (f *FileStorage) Put(key string, value interface{}, ttl time.Duration) erro
 	defer f.mu.Unlock()
 
 	f.cache[key] = cacheItem{Value: value, Expiry: time.Now().Add(ttl)}
-	f.saveToFile()
+	f.markDirty()
 	return nil
 }
 
@@ -120,21 +316,56 @@ func (f *FileStorage) Delete(key string) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	item, existed := f.cache[key]
 	delete(f.cache, key)
-	f.saveToFile()
+	f.markDirty()
+	if existed {
+		if digest, ok := blobDigestForKey(key, item.Value); ok {
+			f.gcBlob(digest)
+		}
+	}
 	return nil
 }
 
 func (f *FileStorage) Stop() error {
-	f.saveToFile()
+	f.janitor.Stop()
+	if f.flusher != nil {
+		f.flusher.Stop()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.dirty {
+		f.saveToFile()
+	}
 	return nil
 }
 
+func (f *FileStorage) Stats() CacheStats {
+	f.mu.Lock()
+	entries := len(f.cache)
+	f.mu.Unlock()
+	return CacheStats{
+		Entries:      entries,
+		ExpiredSwept: atomic.LoadInt64(&f.expiredSwept),
+		Flushes:      atomic.LoadInt64(&f.flushes),
+	}
+}
+
 // RedisStorage
 
 type RedisStorage struct {
 	client *redis.Client
 	ctx    context.Context
+
+	// contentAddressed makes PutStream key blobs by their own sha256
+	// digest instead of the caller-supplied key. See SetContentAddressed.
+	contentAddressed bool
+}
+
+// SetContentAddressed toggles content-addressed mode for blob storage: see
+// BlobStorage.PutStream.
+func (r *RedisStorage) SetContentAddressed(enabled bool) {
+	r.contentAddressed = enabled
 }
 
 func NewRedisStorage(addr, password string, db int) *RedisStorage {
@@ -146,16 +377,33 @@ func NewRedisStorage(addr, password string, db int) *RedisStorage {
 	return &RedisStorage{client: client, ctx: context.Background()}
 }
 
+// RedisParams are the DriverParameters for the "redis" driver.
+type RedisParams struct {
+	Addr     string `json:"Addr"`
+	Password string `json:"Password"`
+	DB       int    `json:"DB"`
+}
+
+func init() {
+	Register("redis", func(params json.RawMessage, logger Logger) (CacheStorage, error) {
+		var p RedisParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, fmt.Errorf("redis driver: %w", err)
+		}
+		return NewRedisStorage(p.Addr, p.Password, p.DB), nil
+	})
+}
+
 func (r *RedisStorage) Get(key string) (interface{}, error) {
 	val, err := r.client.Get(r.ctx, key).Result()
 	if err != nil {
-		return nil, fmt.Errorf("key not found or expired")
+		return nil, ErrNotFound
 	}
 	var item cacheItem
 	_ = json.Unmarshal([]byte(val), &item)
 	if time.Now().After(item.Expiry) {
 		r.client.Del(r.ctx, key)
-		return nil, fmt.Errorf("key not found or expired")
+		return nil, ErrNotFound
 	}
 	return item.Value, nil
 }
@@ -174,98 +422,449 @@ func (r *RedisStorage) Stop() error {
 	return r.client.Close()
 }
 
+// Stats reports the number of keys currently held in the Redis database.
+// Unlike MemoryStorage/FileStorage/S3Storage, RedisStorage runs no janitor
+// of its own -- Redis expires keys natively -- so ExpiredSwept and Flushes
+// are always zero.
+func (r *RedisStorage) Stats() CacheStats {
+	size, err := r.client.DBSize(r.ctx).Result()
+	if err != nil {
+		return CacheStats{}
+	}
+	return CacheStats{Entries: int(size)}
+}
+
 // S3Storage
+//
+// Unlike the other backends, S3Storage stores one object per key (under
+// Prefix) rather than a single monolithic blob, so that concurrent writers
+// sharing a bucket don't clobber each other. See S3Config.RaceWindow for the
+// consistency knob this buys us.
+
+// S3Config configures an S3Storage instance.
+type S3Config struct {
+	Bucket string
+	Prefix string
+	Region string
+
+	// RaceWindow is how long after a PutObject we consider that object
+	// "fresh enough to be racy": a Get that observes an object younger
+	// than RaceWindow will re-HEAD it once the window elapses and retry
+	// the read if a concurrent writer replaced it in the meantime. Zero
+	// disables the re-check.
+	RaceWindow time.Duration
+
+	// UnsafeDelete allows Delete to remove a key younger than
+	// RaceWindow. Without it, Delete refuses such keys so a concurrent
+	// writer's just-published value can't be trashed out from under it.
+	UnsafeDelete bool
+
+	// PartSize and UploadConcurrency tune the multipart uploader used by
+	// PutStream. Zero takes the s3manager default for each.
+	PartSize          int64
+	UploadConcurrency int
+
+	// ContentAddressed makes PutStream ignore the caller's key and store
+	// the blob under its own sha256 digest instead, so identical blobs
+	// uploaded by different callers dedupe onto the same object.
+	ContentAddressed bool
+
+	// SweepInterval controls how often a background janitor lists
+	// objects under Prefix and deletes ones past their Expiry metadata.
+	// Zero disables the sweeper: Get still reports expired objects as
+	// missing, but nothing deletes them from the bucket.
+	SweepInterval time.Duration
+
+	// FlushInterval, if set, makes Put coalesce writes in memory and
+	// upload at most once per interval per key (or on Stop) via a
+	// multipart uploader, instead of issuing a conditional PutObject
+	// synchronously on every call. This trades away the conditional-put
+	// race protection above for throughput under write-heavy/hot-key
+	// workloads: a coalesced write is last-writer-wins within the
+	// window, not IfMatch-checked. Zero (the default) keeps the
+	// synchronous, race-safe behavior.
+	FlushInterval time.Duration
+}
+
+// s3HeadAPI is the single method resolveHead needs off *s3.S3, factored
+// out so the race-window retry logic can be tested against a fake without
+// talking to real S3.
+type s3HeadAPI interface {
+	HeadObject(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+}
+
+// s3PendingPut is a coalesced write buffered in memory until the next
+// flush, used when S3Config.FlushInterval > 0.
+type s3PendingPut struct {
+	value     interface{}
+	expiresAt time.Time
+}
 
 type S3Storage struct {
-	s3        *s3.S3
-	bucket    string
-	cacheFile string
-	cache     map[string]cacheItem
-	mu        sync.Mutex
+	s3     *s3.S3
+	bucket string
+	prefix string
+	cfg    S3Config
+	logger Logger
+
+	janitor *janitor
+	sweepStats
+
+	pendingMu sync.Mutex
+	pending   map[string]s3PendingPut
+	flusher   *janitor
 }
 
-func NewS3Storage(bucket, cacheFile string, region string) *S3Storage {
+// S3Option configures an S3Storage at construction time.
+type S3Option func(*S3Storage)
+
+// WithS3Logger redirects flushPending's diagnostics through logger instead
+// of the standard logger. Use this to thread a Driver's caller-supplied
+// Logger (see OpenWithLogger) down into the backend that actually logs.
+func WithS3Logger(logger Logger) S3Option {
+	return func(s *S3Storage) { s.logger = logger }
+}
+
+func NewS3Storage(cfg S3Config, opts ...S3Option) *S3Storage {
 	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(region),
+		Region: aws.String(cfg.Region),
 	}))
-	s3Client := s3.New(sess)
-	storage := &S3Storage{
-		s3:        s3Client,
-		bucket:    bucket,
-		cacheFile: cacheFile,
-		cache:     make(map[string]cacheItem),
+	s := &S3Storage{
+		s3:      s3.New(sess),
+		bucket:  cfg.Bucket,
+		prefix:  cfg.Prefix,
+		cfg:     cfg,
+		logger:  log.New(os.Stderr, "", log.LstdFlags),
+		pending: make(map[string]s3PendingPut),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
-	storage.loadFromS3()
-	return storage
+	s.janitor = newJanitor(cfg.SweepInterval, s.sweep)
+	if cfg.FlushInterval > 0 {
+		s.flusher = newJanitor(cfg.FlushInterval, s.flushPending)
+	}
+	return s
 }
 
-func (s *S3Storage) loadFromS3() {
-	output, err := s.s3.GetObject(&s3.GetObjectInput{
+func (s *S3Storage) objectKey(key string) string {
+	return s.prefix + key
+}
+
+// sweep lists every object under the prefix and deletes ones past their
+// Expiry metadata; it's the janitor's periodic callback. Unlike the memory
+// and file backends, S3Storage's Get does not delete an expired object (it
+// only reports it as missing), so this is what reclaims its storage.
+func (s *S3Storage) sweep() {
+	swept := 0
+	err := s.s3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(s.cacheFile),
+		Prefix: aws.String(s.prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			key := (*obj.Key)[len(s.prefix):]
+			head, err := s.s3.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(s.bucket), Key: obj.Key})
+			if err != nil {
+				continue
+			}
+			expiry, ok := head.Metadata["Expiry"]
+			if !ok || expiry == nil {
+				continue
+			}
+			expiresAt, err := time.Parse(time.RFC3339Nano, *expiry)
+			if err != nil || !time.Now().After(expiresAt) {
+				continue
+			}
+			if err := s.Delete(key); err == nil {
+				swept++
+			}
+		}
+		return true
 	})
-	if err == nil && output.Body != nil {
-		defer output.Body.Close()
-		data, _ := ioutil.ReadAll(output.Body)
-		_ = json.Unmarshal(data, &s.cache)
+	if err != nil {
+		return
 	}
+	s.recordSweep(swept)
 }
 
-func (s *S3Storage) saveToS3() {
-	data, _ := json.Marshal(s.cache)
-	_, _ = s.s3.PutObject(&s3.PutObjectInput{
+// Keys lists every key currently stored under Prefix, making S3Storage
+// usable as a TieredCache SourceOfTruth tier for repair.
+func (s *S3Storage) Keys() ([]string, error) {
+	var keys []string
+	err := s.s3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(s.cacheFile),
-		Body:   ioutil.NopCloser(stringReader(data)),
+		Prefix: aws.String(s.prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			key := (*obj.Key)[len(s.prefix):]
+			if strings.HasPrefix(key, "staging/") {
+				continue
+			}
+			keys = append(keys, key)
+		}
+		return true
 	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
 }
 
-func stringReader(data []byte) *string {
-	str := string(data)
-	return &str
+func init() {
+	Register("s3", func(params json.RawMessage, logger Logger) (CacheStorage, error) {
+		var cfg S3Config
+		if err := json.Unmarshal(params, &cfg); err != nil {
+			return nil, fmt.Errorf("s3 driver: %w", err)
+		}
+		return NewS3Storage(cfg, WithS3Logger(logger)), nil
+	})
+}
+
+// maxRaceWindowRetries bounds how many times Get will re-HEAD an object
+// that keeps changing ETag faster than RaceWindow elapses, so a hot key
+// can't block a reader forever.
+const maxRaceWindowRetries = 3
+
+// resolveHead HEADs bucket/objKey and, while window > 0, re-HEADs it after
+// waiting out any remaining race window until the ETag stops changing or
+// maxRetries is hit. Factored out of Get so the retry bound is testable
+// against a fake s3HeadAPI without talking to real S3.
+func resolveHead(api s3HeadAPI, bucket, objKey string, window time.Duration, maxRetries int) (*s3.HeadObjectOutput, error) {
+	var head *s3.HeadObjectOutput
+	for attempt := 0; ; attempt++ {
+		var err error
+		head, err = api.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(objKey),
+		})
+		if err != nil {
+			return nil, ErrNotFound
+		}
+
+		if window == 0 || attempt >= maxRetries {
+			return head, nil
+		}
+		age := time.Since(*head.LastModified)
+		if age >= window {
+			return head, nil
+		}
+		time.Sleep(window - age)
+		confirm, err := api.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(objKey),
+		})
+		if err != nil {
+			return nil, ErrNotFound
+		}
+		if aws.StringValue(confirm.ETag) == aws.StringValue(head.ETag) {
+			return confirm, nil
+		}
+		// A concurrent writer replaced the object while we were
+		// waiting out the race window; loop and re-HEAD it, up to
+		// maxRetries times, instead of recursing unboundedly.
+	}
 }
 
 func (s *S3Storage) Get(key string) (interface{}, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	if value, ok := s.getPending(key); ok {
+		return value, nil
+	}
 
-	item, exists := s.cache[key]
-	if !exists || time.Now().After(item.Expiry) {
-		delete(s.cache, key)
-		s.saveToS3()
-		return nil, fmt.Errorf("key not found or expired")
+	objKey := s.objectKey(key)
+
+	head, err := resolveHead(s.s3, s.bucket, objKey, s.cfg.RaceWindow, maxRaceWindowRetries)
+	if err != nil {
+		return nil, err
 	}
-	return item.Value, nil
+
+	if expiry, ok := head.Metadata["Expiry"]; ok && expiry != nil {
+		expiresAt, err := time.Parse(time.RFC3339Nano, *expiry)
+		if err == nil && time.Now().After(expiresAt) {
+			return nil, ErrNotFound
+		}
+	}
+
+	output, err := s.s3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objKey),
+	})
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	defer output.Body.Close()
+
+	data, err := ioutil.ReadAll(output.Body)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
 }
 
 func (s *S3Storage) Put(key string, value interface{}, ttl time.Duration) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	if s.cfg.FlushInterval > 0 {
+		s.pendingMu.Lock()
+		s.pending[key] = s3PendingPut{value: value, expiresAt: time.Now().Add(ttl)}
+		s.pendingMu.Unlock()
+		return nil
+	}
 
-	s.cache[key] = cacheItem{Value: value, Expiry: time.Now().Add(ttl)}
-	s.saveToS3()
-	return nil
+	objKey := s.objectKey(key)
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objKey),
+		Body:   bytes.NewReader(data),
+		Metadata: map[string]*string{
+			"Expiry": aws.String(time.Now().Add(ttl).Format(time.RFC3339Nano)),
+		},
+	}
+
+	// Conditional put: reject the write if another writer raced us,
+	// rather than silently clobbering it. A brand-new key must not
+	// already exist (IfNoneMatch); an update to an existing key must
+	// still match the version we last observed (IfMatch on its ETag).
+	existing, headErr := s.s3.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(objKey)})
+	input.IfNoneMatch, input.IfMatch = conditionalPutHeaders(existing, headErr)
+
+	_, err = s.s3.PutObject(input)
+	return err
+}
+
+// conditionalPutHeaders decides the If-None-Match/If-Match headers a Put
+// should send given whether the key already exists: a new key must not
+// already exist (IfNoneMatch: "*"); an existing key's write must still
+// match the ETag last observed (IfMatch), so a concurrent update since
+// then is rejected instead of silently clobbered.
+func conditionalPutHeaders(existing *s3.HeadObjectOutput, headErr error) (ifNoneMatch, ifMatch *string) {
+	if headErr != nil {
+		return aws.String("*"), nil
+	}
+	return nil, existing.ETag
 }
 
 func (s *S3Storage) Delete(key string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.pendingMu.Lock()
+	delete(s.pending, key)
+	s.pendingMu.Unlock()
+
+	objKey := s.objectKey(key)
+
+	if !s.cfg.UnsafeDelete && s.cfg.RaceWindow > 0 {
+		head, err := s.s3.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(objKey),
+		})
+		if err == nil && time.Since(*head.LastModified) < s.cfg.RaceWindow {
+			return fmt.Errorf("refusing to delete %q: written within the race window, set UnsafeDelete to override", key)
+		}
+	}
 
-	delete(s.cache, key)
-	s.saveToS3()
-	return nil
+	_, err := s.s3.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objKey),
+	})
+	return err
 }
 
 func (s *S3Storage) Stop() error {
-	s.saveToS3()
+	s.janitor.Stop()
+	if s.flusher != nil {
+		s.flusher.Stop()
+		s.flushPending()
+	}
 	return nil
 }
 
+func (s *S3Storage) Stats() CacheStats {
+	return CacheStats{
+		ExpiredSwept: atomic.LoadInt64(&s.expiredSwept),
+		Flushes:      atomic.LoadInt64(&s.flushes),
+	}
+}
+
+// getPending returns a buffered-but-not-yet-uploaded value for key, if
+// FlushInterval coalescing is enabled and a write is pending. This keeps
+// Get consistent with a Put that hasn't flushed yet.
+func (s *S3Storage) getPending(key string) (interface{}, bool) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	entry, ok := s.pending[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(s.pending, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// flushPending uploads every coalesced write accumulated since the last
+// flush via a multipart uploader, draining the pending buffer first so
+// writes that land mid-flush aren't lost. It's the flusher janitor's
+// periodic callback, and is also called once synchronously from Stop.
+func (s *S3Storage) flushPending() {
+	s.pendingMu.Lock()
+	batch := s.pending
+	s.pending = make(map[string]s3PendingPut)
+	s.pendingMu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	uploader := s3manager.NewUploaderWithClient(s.s3, func(u *s3manager.Uploader) {
+		if s.cfg.PartSize > 0 {
+			u.PartSize = s.cfg.PartSize
+		}
+		if s.cfg.UploadConcurrency > 0 {
+			u.Concurrency = s.cfg.UploadConcurrency
+		}
+	})
+
+	flushed := 0
+	for key, entry := range batch {
+		data, err := json.Marshal(entry.value)
+		if err != nil {
+			s.logger.Printf("s3 cache: flush: marshal %q: %v", key, err)
+			continue
+		}
+		_, err = uploader.Upload(&s3manager.UploadInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.objectKey(key)),
+			Body:   bytes.NewReader(data),
+			Metadata: map[string]*string{
+				"Expiry": aws.String(entry.expiresAt.Format(time.RFC3339Nano)),
+			},
+		})
+		if err != nil {
+			s.logger.Printf("s3 cache: flush: upload %q: %v", key, err)
+			continue
+		}
+		flushed++
+	}
+	if flushed > 0 {
+		s.recordFlush()
+	}
+}
+
 // Example Usage
 func main() {
 	memoryStorage := NewMemoryStorage()
 	fileStorage := NewFileStorage("cache.json")
-	s3Storage := NewS3Storage("my-bucket", "cache.json", "us-east-1")
+	s3Storage := NewS3Storage(S3Config{
+		Bucket:     "my-bucket",
+		Prefix:     "cache/",
+		Region:     "us-east-1",
+		RaceWindow: 2 * time.Second,
+	})
 
 	memoryStorage.Put("test", "value", 5*time.Second)
 	result, err := memoryStorage.Get("test")
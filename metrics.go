@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// statsPollInterval is how often a MetricsCache refreshes the gauges/
+// counters backed by an inner backend's StatsProvider.
+const statsPollInterval = 5 * time.Second
+
+// MetricsCache wraps a CacheStorage backend and records Prometheus metrics
+// for every call, labelled by backend kind (e.g. "memory", "file", "redis",
+// "s3") and a caller-supplied instance name so that several instances of the
+// same backend can be told apart on one dashboard.
+type MetricsCache struct {
+	inner    CacheStorage
+	backend  string
+	instance string
+
+	hits         *prometheus.CounterVec
+	misses       *prometheus.CounterVec
+	evictions    *prometheus.CounterVec
+	expiredSwept *prometheus.CounterVec
+	flushes      *prometheus.CounterVec
+	errors       *prometheus.CounterVec
+	latency      *prometheus.HistogramVec
+	entries      *prometheus.GaugeVec
+	bytesUsed    *prometheus.GaugeVec
+
+	// hasStatsProvider is true when inner implements StatsProvider, in
+	// which case pollStats is the sole source of truth for the entries
+	// gauge: Put/Delete must not also Inc/Dec it, or double-deletes and
+	// overwrites would drift it away from the backend's real count
+	// between polls.
+	hasStatsProvider bool
+
+	pollStop     chan struct{}
+	pollStopOnce sync.Once
+}
+
+// MetricsOption configures a MetricsCache at construction time.
+type MetricsOption func(*metricsConfig)
+
+type metricsConfig struct {
+	registerer prometheus.Registerer
+}
+
+// WithRegisterer plugs the MetricsCache into an existing Prometheus
+// registry instead of the default global one.
+func WithRegisterer(reg prometheus.Registerer) MetricsOption {
+	return func(c *metricsConfig) {
+		c.registerer = reg
+	}
+}
+
+// NewMetricsCache wraps inner so that every Get/Put/Delete call is observed.
+// backend identifies the storage kind ("memory", "file", "redis", "s3") and
+// instance distinguishes multiple caches of the same kind.
+func NewMetricsCache(inner CacheStorage, backend, instance string, opts ...MetricsOption) *MetricsCache {
+	cfg := metricsConfig{registerer: prometheus.DefaultRegisterer}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	labels := []string{"backend", "instance"}
+	factory := promauto.With(cfg.registerer)
+
+	m := &MetricsCache{
+		inner:    inner,
+		backend:  backend,
+		instance: instance,
+
+		hits: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "lru_cache_hits_total",
+			Help: "Number of cache Get calls that returned a live value.",
+		}, labels),
+		misses: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "lru_cache_misses_total",
+			Help: "Number of cache Get calls that found nothing or an expired entry.",
+		}, labels),
+		evictions: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "lru_cache_evictions_total",
+			Help: "Number of Delete calls that the backend completed without error.",
+		}, labels),
+		expiredSwept: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "lru_cache_expired_swept_total",
+			Help: "Number of expired entries removed by a background sweep rather than on read.",
+		}, labels),
+		flushes: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "lru_cache_flushes_total",
+			Help: "Number of times a dirty in-memory cache was flushed to disk/S3.",
+		}, labels),
+		errors: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "lru_cache_backend_errors_total",
+			Help: "Number of backend errors (excluding ordinary not-found/expired misses).",
+		}, append(append([]string{}, labels...), "op")),
+		latency: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "lru_cache_operation_duration_seconds",
+			Help:    "Latency of Get/Put/Delete calls against the underlying backend.",
+			Buckets: prometheus.DefBuckets,
+		}, append(append([]string{}, labels...), "op")),
+		entries: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "lru_cache_entries",
+			Help: "Approximate number of entries currently held by the backend.",
+		}, labels),
+		bytesUsed: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "lru_cache_bytes_used",
+			Help: "Size in bytes of the JSON encoding of the value most recently returned by Get; not a total over the entries held by the backend.",
+		}, labels),
+
+		pollStop: make(chan struct{}),
+	}
+
+	if sp, ok := inner.(StatsProvider); ok {
+		m.hasStatsProvider = true
+		go m.pollStats(sp)
+	}
+	return m
+}
+
+// pollStats periodically pulls CacheStats from a backend that tracks janitor
+// sweep/flush activity and folds them into the Prometheus metrics, since
+// that activity doesn't happen on the Get/Put/Delete call path this decorator
+// otherwise instruments.
+func (m *MetricsCache) pollStats(sp StatsProvider) {
+	ticker := time.NewTicker(statsPollInterval)
+	defer ticker.Stop()
+
+	var lastSwept, lastFlushes int64
+	for {
+		select {
+		case <-m.pollStop:
+			return
+		case <-ticker.C:
+			stats := sp.Stats()
+			if d := stats.ExpiredSwept - lastSwept; d > 0 {
+				m.expiredSwept.WithLabelValues(m.backend, m.instance).Add(float64(d))
+				lastSwept = stats.ExpiredSwept
+			}
+			if d := stats.Flushes - lastFlushes; d > 0 {
+				m.flushes.WithLabelValues(m.backend, m.instance).Add(float64(d))
+				lastFlushes = stats.Flushes
+			}
+			m.entries.WithLabelValues(m.backend, m.instance).Set(float64(stats.Entries))
+		}
+	}
+}
+
+func (m *MetricsCache) observe(op string, start time.Time, err error) {
+	m.latency.WithLabelValues(m.backend, m.instance, op).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		m.errors.WithLabelValues(m.backend, m.instance, op).Inc()
+	}
+}
+
+func (m *MetricsCache) Get(key string) (interface{}, error) {
+	start := time.Now()
+	value, err := m.inner.Get(key)
+	m.observe("get", start, err)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			m.misses.WithLabelValues(m.backend, m.instance).Inc()
+		}
+		return nil, err
+	}
+	m.hits.WithLabelValues(m.backend, m.instance).Inc()
+	// The gauge is set to this one value's marshaled size, replacing what
+	// the previous Get set; sizes are not summed across entries.
+	if data, err := json.Marshal(value); err == nil {
+		m.bytesUsed.WithLabelValues(m.backend, m.instance).Set(float64(len(data)))
+	}
+	return value, nil
+}
+
+func (m *MetricsCache) Put(key string, value interface{}, ttl time.Duration) error {
+	start := time.Now()
+	err := m.inner.Put(key, value, ttl)
+	m.observe("put", start, err)
+	if err == nil && !m.hasStatsProvider {
+		m.entries.WithLabelValues(m.backend, m.instance).Inc()
+	}
+	return err
+}
+
+func (m *MetricsCache) Delete(key string) error {
+	start := time.Now()
+	err := m.inner.Delete(key)
+	m.observe("delete", start, err)
+	if err == nil {
+		m.evictions.WithLabelValues(m.backend, m.instance).Inc()
+		if !m.hasStatsProvider {
+			m.entries.WithLabelValues(m.backend, m.instance).Dec()
+		}
+	}
+	return err
+}
+
+func (m *MetricsCache) Stop() error {
+	m.pollStopOnce.Do(func() { close(m.pollStop) })
+	return m.inner.Stop()
+}
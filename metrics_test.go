@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeStatsStorage is a fakeStorage that also implements StatsProvider, so
+// MetricsCache wires it through pollStats rather than the naive Inc/Dec
+// path.
+type fakeStatsStorage struct {
+	*fakeStorage
+}
+
+func newFakeStatsStorage() *fakeStatsStorage {
+	return &fakeStatsStorage{fakeStorage: newFakeStorage()}
+}
+
+func (f *fakeStatsStorage) Stats() CacheStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return CacheStats{Entries: len(f.data)}
+}
+
+func TestMetricsCacheEntriesGaugeDoesNotDriftWithStatsProvider(t *testing.T) {
+	backend := newFakeStatsStorage()
+	m := NewMetricsCache(backend, "fake", "stats-backed", WithRegisterer(prometheus.NewRegistry()))
+	defer m.Stop()
+
+	if err := m.Put("k", "v", time.Minute); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// pollStats hasn't ticked yet, so a StatsProvider-backed cache's Put
+	// must not have nudged the gauge itself.
+	if got := testutil.ToFloat64(m.entries.WithLabelValues("fake", "stats-backed")); got != 0 {
+		t.Fatalf("entries gauge = %v immediately after Put, want 0", got)
+	}
+
+	if err := m.Delete("k"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := m.Delete("k"); err != nil {
+		t.Fatalf("double Delete: %v", err)
+	}
+	if got := testutil.ToFloat64(m.entries.WithLabelValues("fake", "stats-backed")); got != 0 {
+		t.Fatalf("entries gauge = %v after Put+double-Delete, want 0 (must not drift negative)", got)
+	}
+}
+
+func TestMetricsCacheEntriesGaugeTracksPutDeleteWithoutStatsProvider(t *testing.T) {
+	backend := newFakeStorage()
+	m := NewMetricsCache(backend, "fake", "no-stats", WithRegisterer(prometheus.NewRegistry()))
+	defer m.Stop()
+
+	if err := m.Put("k", "v", time.Minute); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if got := testutil.ToFloat64(m.entries.WithLabelValues("fake", "no-stats")); got != 1 {
+		t.Fatalf("entries gauge = %v after one Put on a non-StatsProvider backend, want 1", got)
+	}
+
+	if err := m.Delete("k"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got := testutil.ToFloat64(m.entries.WithLabelValues("fake", "no-stats")); got != 0 {
+		t.Fatalf("entries gauge = %v after Delete, want 0", got)
+	}
+}
+
+func TestMetricsCacheBytesUsedReflectsMarshaledSize(t *testing.T) {
+	backend := newFakeStorage()
+	m := NewMetricsCache(backend, "fake", "bytes", WithRegisterer(prometheus.NewRegistry()))
+	defer m.Stop()
+
+	if err := m.Put("k", "hello", time.Minute); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := m.Get("k"); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	// "hello" marshals to the JSON string literal `"hello"` (7 bytes).
+	if got := testutil.ToFloat64(m.bytesUsed.WithLabelValues("fake", "bytes")); got != 7 {
+		t.Fatalf("bytesUsed gauge = %v, want 7", got)
+	}
+}
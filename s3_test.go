@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// fakeHeadAPI returns a scripted sequence of HeadObject responses, so
+// resolveHead's retry bound can be tested without real S3.
+type fakeHeadAPI struct {
+	responses []*s3.HeadObjectOutput
+	calls     int
+	// fresh stamps each response's LastModified with the time of the call,
+	// so every HEAD reports an object younger than the race window.
+	fresh bool
+}
+
+func (f *fakeHeadAPI) HeadObject(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	i := f.calls
+	if i >= len(f.responses) {
+		i = len(f.responses) - 1
+	}
+	f.calls++
+	out := *f.responses[i]
+	if f.fresh {
+		now := time.Now()
+		out.LastModified = &now
+	}
+	return &out, nil
+}
+
+func TestResolveHeadCapsRetriesOnChangingETag(t *testing.T) {
+	now := time.Now()
+	// Every HeadObject call reports a brand-new object and a different
+	// ETag than the one before, so without a retry cap resolveHead would
+	// recurse/sleep forever.
+	var responses []*s3.HeadObjectOutput
+	for i := 0; i < maxRaceWindowRetries+5; i++ {
+		responses = append(responses, &s3.HeadObjectOutput{
+			LastModified: &now,
+			ETag:         aws.String(string(rune('a' + i))),
+		})
+	}
+	api := &fakeHeadAPI{responses: responses, fresh: true}
+
+	start := time.Now()
+	_, err := resolveHead(api, "bucket", "key", 10*time.Millisecond, maxRaceWindowRetries)
+	if err != nil {
+		t.Fatalf("resolveHead: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("resolveHead took %v, want it bounded by maxRaceWindowRetries", elapsed)
+	}
+	// Each retry issues two HeadObject calls (initial + confirm), capped
+	// at maxRaceWindowRetries iterations.
+	if api.calls > 2*(maxRaceWindowRetries+1) {
+		t.Fatalf("resolveHead made %d HeadObject calls, want it capped near 2*maxRaceWindowRetries", api.calls)
+	}
+}
+
+func TestResolveHeadStopsOnStableETag(t *testing.T) {
+	now := time.Now()
+	api := &fakeHeadAPI{responses: []*s3.HeadObjectOutput{
+		{LastModified: &now, ETag: aws.String("stable")},
+	}}
+
+	head, err := resolveHead(api, "bucket", "key", 10*time.Millisecond, maxRaceWindowRetries)
+	if err != nil {
+		t.Fatalf("resolveHead: %v", err)
+	}
+	if aws.StringValue(head.ETag) != "stable" {
+		t.Fatalf("got ETag %q, want %q", aws.StringValue(head.ETag), "stable")
+	}
+	if api.calls != 2 {
+		t.Fatalf("expected one initial HEAD and one confirm HEAD, got %d calls", api.calls)
+	}
+}
+
+func TestConditionalPutHeadersNewKey(t *testing.T) {
+	ifNoneMatch, ifMatch := conditionalPutHeaders(nil, errors.New("not found"))
+	if aws.StringValue(ifNoneMatch) != "*" {
+		t.Fatalf("expected IfNoneMatch=*, got %v", ifNoneMatch)
+	}
+	if ifMatch != nil {
+		t.Fatalf("expected no IfMatch for a new key, got %v", ifMatch)
+	}
+}
+
+func TestConditionalPutHeadersExistingKey(t *testing.T) {
+	existing := &s3.HeadObjectOutput{ETag: aws.String("etag-123")}
+	ifNoneMatch, ifMatch := conditionalPutHeaders(existing, nil)
+	if ifNoneMatch != nil {
+		t.Fatalf("expected no IfNoneMatch for an existing key, got %v", ifNoneMatch)
+	}
+	if aws.StringValue(ifMatch) != "etag-123" {
+		t.Fatalf("expected IfMatch=etag-123, got %v", ifMatch)
+	}
+}
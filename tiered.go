@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// KeyLister is an optional capability a CacheStorage backend can implement
+// so TieredCache's repair goroutine can enumerate its keys. Backends that
+// don't implement it simply aren't usable as a repair source of truth.
+type KeyLister interface {
+	Keys() ([]string, error)
+}
+
+// TieredCache composes an ordered list of CacheStorage backends, fastest
+// first, into a single CacheStorage. Get walks the tiers L1->Ln and
+// promotes a hit back into the faster tiers it missed on. Put fans out to
+// the first Replication tiers concurrently and succeeds once Replication of
+// them acknowledge. Delete fans out to every tier.
+type TieredCache struct {
+	tiers       []CacheStorage
+	replication int
+
+	// SourceOfTruth, if >= 0, names the tier index the repair goroutine
+	// treats as authoritative: any key found there but missing from a
+	// faster tier gets copied forward.
+	sourceOfTruth int
+	repairEvery   time.Duration
+	logger        Logger
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// TieredOption configures a TieredCache at construction time.
+type TieredOption func(*TieredCache)
+
+// WithTieredLogger redirects the repair loop's and source-of-truth write's
+// diagnostics through logger instead of the standard logger.
+func WithTieredLogger(logger Logger) TieredOption {
+	return func(t *TieredCache) { t.logger = logger }
+}
+
+// NewTieredCache builds a TieredCache over tiers (ordered fastest to
+// slowest). replication is how many of the leading tiers a Put must reach
+// before it's considered successful; it's clamped to len(tiers). tiers may
+// be empty, in which case replication is at most 0 and every Put fails
+// cleanly instead of indexing into an empty slice.
+func NewTieredCache(tiers []CacheStorage, replication int, opts ...TieredOption) *TieredCache {
+	if replication > len(tiers) {
+		replication = len(tiers)
+	}
+	if replication < 1 && len(tiers) > 0 {
+		replication = 1
+	}
+	t := &TieredCache{
+		tiers:         tiers,
+		replication:   replication,
+		sourceOfTruth: len(tiers) - 1,
+		logger:        log.New(os.Stderr, "", log.LstdFlags),
+		stop:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// ReplicatedCache is a TieredCache configured to replicate every Put to all
+// of its tiers rather than just the fastest few.
+func ReplicatedCache(tiers []CacheStorage, opts ...TieredOption) *TieredCache {
+	return NewTieredCache(tiers, len(tiers), opts...)
+}
+
+// StartRepair launches the background goroutine that periodically scans
+// the SourceOfTruth tier (if it implements KeyLister) and re-populates any
+// key missing from a faster tier. Call Stop to shut it down.
+func (t *TieredCache) StartRepair(interval time.Duration) {
+	t.repairEvery = interval
+	go t.repairLoop()
+}
+
+func (t *TieredCache) repairLoop() {
+	ticker := time.NewTicker(t.repairEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.repairOnce()
+		}
+	}
+}
+
+func (t *TieredCache) repairOnce() {
+	if t.sourceOfTruth < 0 || t.sourceOfTruth >= len(t.tiers) {
+		return
+	}
+	truth, ok := t.tiers[t.sourceOfTruth].(KeyLister)
+	if !ok {
+		return
+	}
+	keys, err := truth.Keys()
+	if err != nil {
+		t.logger.Printf("tiered cache: repair: listing keys on source of truth: %v", err)
+		return
+	}
+	for _, key := range keys {
+		value, err := t.tiers[t.sourceOfTruth].Get(key)
+		if err != nil {
+			continue
+		}
+		for i := 0; i < t.sourceOfTruth; i++ {
+			if _, err := t.tiers[i].Get(key); err == nil {
+				continue
+			}
+			// Missing from a faster tier; repopulate it. The TTL
+			// isn't known at this layer, so give it a short one and
+			// let the next real Get refresh it from upstream.
+			_ = t.tiers[i].Put(key, value, time.Minute)
+		}
+	}
+}
+
+// Get walks tiers L1->Ln and returns the first hit, promoting it back into
+// the faster tiers it missed. A failing tier (e.g. a Redis outage) is
+// skipped rather than aborting the whole lookup.
+func (t *TieredCache) Get(key string) (interface{}, error) {
+	for i, tier := range t.tiers {
+		value, err := tier.Get(key)
+		if err != nil {
+			continue
+		}
+		for j := 0; j < i; j++ {
+			_ = t.tiers[j].Put(key, value, time.Minute)
+		}
+		return value, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Put fans out to the first Replication tiers concurrently and only
+// returns success once all of them have acknowledged the write. It also
+// kicks off an async write to the SourceOfTruth tier when that tier isn't
+// already among the replicated ones, so the repair loop always has
+// something authoritative to repair from.
+func (t *TieredCache) Put(key string, value interface{}, ttl time.Duration) error {
+	if len(t.tiers) == 0 {
+		return fmt.Errorf("tiered cache: no tiers configured")
+	}
+	n := t.replication
+	results := make(chan error, n)
+	for i := 0; i < n; i++ {
+		tier := t.tiers[i]
+		go func() {
+			results <- tier.Put(key, value, ttl)
+		}()
+	}
+
+	acked := 0
+	var lastErr error
+	for i := 0; i < n; i++ {
+		if err := <-results; err != nil {
+			lastErr = err
+			continue
+		}
+		acked++
+	}
+
+	if t.sourceOfTruth >= n && t.sourceOfTruth < len(t.tiers) {
+		truth := t.tiers[t.sourceOfTruth]
+		go func() {
+			if err := truth.Put(key, value, ttl); err != nil {
+				t.logger.Printf("tiered cache: async write to source of truth tier %d: %v", t.sourceOfTruth, err)
+			}
+		}()
+	}
+
+	if acked != n {
+		return fmt.Errorf("tiered cache: put acknowledged by %d/%d replicated tiers: %w", acked, n, lastErr)
+	}
+	return nil
+}
+
+// Delete fans out to every tier. It isolates per-tier failures, only
+// reporting an error if every tier failed.
+func (t *TieredCache) Delete(key string) error {
+	results := make(chan error, len(t.tiers))
+	for _, tier := range t.tiers {
+		tier := tier
+		go func() {
+			results <- tier.Delete(key)
+		}()
+	}
+
+	failures := 0
+	var lastErr error
+	for range t.tiers {
+		if err := <-results; err != nil {
+			lastErr = err
+			failures++
+		}
+	}
+	if failures == len(t.tiers) {
+		return fmt.Errorf("tiered cache: delete failed on all tiers: %w", lastErr)
+	}
+	return nil
+}
+
+func (t *TieredCache) Stop() error {
+	t.stopOnce.Do(func() { close(t.stop) })
+	var lastErr error
+	for _, tier := range t.tiers {
+		if err := tier.Stop(); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
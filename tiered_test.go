@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStorage is a minimal in-memory CacheStorage for exercising
+// TieredCache's fan-out and repair logic without a real backend.
+type fakeStorage struct {
+	mu      sync.Mutex
+	data    map[string]interface{}
+	failPut bool
+	failGet bool
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{data: make(map[string]interface{})}
+}
+
+func (f *fakeStorage) Get(key string) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failGet {
+		return nil, errors.New("backend unavailable")
+	}
+	v, ok := f.data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return v, nil
+}
+
+func (f *fakeStorage) Put(key string, value interface{}, ttl time.Duration) error {
+	if f.failPut {
+		return errors.New("put failed")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data[key] = value
+	return nil
+}
+
+func (f *fakeStorage) Delete(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.data, key)
+	return nil
+}
+
+func (f *fakeStorage) Stop() error { return nil }
+
+func (f *fakeStorage) Keys() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.data))
+	for k := range f.data {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func TestTieredCachePutRequiresAllReplicas(t *testing.T) {
+	t1, t2 := newFakeStorage(), newFakeStorage()
+	tc := NewTieredCache([]CacheStorage{t1, t2}, 2)
+
+	t2.failPut = true
+	if err := tc.Put("k", "v", time.Minute); err == nil {
+		t.Fatal("expected Put to fail when only 1 of 2 replicas acked")
+	}
+
+	t2.failPut = false
+	if err := tc.Put("k", "v", time.Minute); err != nil {
+		t.Fatalf("expected Put to succeed once all replicas ack: %v", err)
+	}
+}
+
+func TestTieredCachePutWritesSourceOfTruth(t *testing.T) {
+	fast, truth := newFakeStorage(), newFakeStorage()
+	tc := NewTieredCache([]CacheStorage{fast, truth}, 1)
+
+	if err := tc.Put("k", "v", time.Minute); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	deadline := time.After(time.Second)
+	for {
+		if _, err := truth.Get("k"); err == nil {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("source of truth tier never received the async write")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestTieredCacheRepairPopulatesFasterTiers(t *testing.T) {
+	fast, truth := newFakeStorage(), newFakeStorage()
+	truth.data["k"] = "v"
+
+	tc := NewTieredCache([]CacheStorage{fast, truth}, 1)
+	tc.repairOnce()
+
+	if v, err := fast.Get("k"); err != nil || v != "v" {
+		t.Fatalf("expected repair to copy %q into the faster tier, got %v, %v", "v", v, err)
+	}
+}
+
+func TestTieredCacheNoTiersFailsCleanly(t *testing.T) {
+	tc := NewTieredCache(nil, 3)
+
+	if err := tc.Put("k", "v", time.Minute); err == nil {
+		t.Fatal("expected Put against a tierless cache to fail instead of panicking")
+	}
+	if _, err := tc.Get("k"); err == nil {
+		t.Fatal("expected Get against a tierless cache to report a miss")
+	}
+}
+
+func TestTieredCacheGetSkipsFailingTier(t *testing.T) {
+	broken := newFakeStorage()
+	broken.failGet = true
+	healthy := newFakeStorage()
+	healthy.data["k"] = "v"
+
+	tc := NewTieredCache([]CacheStorage{broken, healthy}, 1)
+	v, err := tc.Get("k")
+	if err != nil || v != "v" {
+		t.Fatalf("expected Get to fall through to the healthy tier, got %v, %v", v, err)
+	}
+}